@@ -0,0 +1,185 @@
+package traefik_outgoing_oauth2_cc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// ClientAssertionConfig configures RFC 7523 private_key_jwt client authentication for the
+// auth-grant request, used instead of HTTP Basic auth when set.
+type ClientAssertionConfig struct {
+	Key       string `json:"key,omitempty"`       // private key in PEM form; supports the ~file~/~env~ flexible-value syntax
+	Algorithm string `json:"algorithm,omitempty"` // RS256, ES256 or PS256, defaults to RS256
+	KeyID     string `json:"keyId,omitempty"`     // populates the JWT "kid" header, if set
+	Audience  string `json:"audience,omitempty"`  // JWT "aud" claim, defaults to the auth-grant URL
+}
+
+const clientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+const clientAssertionLifetime = 60 * time.Second
+
+// clientAssertionSigner mints a short-lived RFC 7523 client-assertion JWT for each auth-grant request.
+// audience is the explicit "aud" override from config; when empty, assertion falls back to
+// whatever auth-grant URL the caller resolved for this request (see assertion).
+type clientAssertionSigner struct {
+	clientID  string
+	audience  string
+	algorithm string
+	keyID     string
+	signer    crypto.Signer
+}
+
+func newClientAssertionSigner(cfg ClientAssertionConfig, clientID string) (*clientAssertionSigner, error) {
+	keyPEM, err := fromFlexibleField("clientAssertion.key", cfg.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	algorithm := cfg.Algorithm
+	if algorithm == "" {
+		algorithm = "RS256"
+	}
+
+	signer, err := parseClientAssertionKey(algorithm, []byte(keyPEM))
+	if err != nil {
+		return nil, fmt.Errorf("outgoing-oauth2-cc failed to parse clientAssertion.key: %v", err)
+	}
+
+	return &clientAssertionSigner{
+		clientID:  clientID,
+		audience:  cfg.Audience,
+		algorithm: algorithm,
+		keyID:     cfg.KeyID,
+		signer:    signer,
+	}, nil
+}
+
+// parseClientAssertionKey decodes a PEM-encoded private key, preferring PKCS#8 (which covers RSA
+// and EC keys alike) and falling back to the algorithm-specific legacy formats.
+func parseClientAssertionKey(algorithm string, pemBytes []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return nil, fmt.Errorf("not a valid PEM block")
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported key type %T", key)
+		}
+		return signer, nil
+	}
+	if algorithm == "ES256" {
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	return x509.ParsePKCS1PrivateKey(block.Bytes)
+}
+
+// assertion mints a fresh client-assertion JWT per RFC 7523: iss=sub=clientID, a random jti and a
+// short exp, signed with the configured algorithm and key. resolvedGrantUrl is used as the "aud"
+// claim unless an explicit ClientAssertion.Audience was configured; it is looked up fresh on every
+// call (rather than baked in at construction) so a grant URL resolved lazily via OIDC discovery,
+// after New, still ends up in the assertion.
+func (s *clientAssertionSigner) assertion(resolvedGrantUrl string) (string, error) {
+	audience := s.audience
+	if audience == "" {
+		audience = resolvedGrantUrl
+	}
+	now := time.Now()
+	header := map[string]interface{}{
+		"alg": s.algorithm,
+		"typ": "JWT",
+	}
+	if s.keyID != "" {
+		header["kid"] = s.keyID
+	}
+	claims := map[string]interface{}{
+		"iss": s.clientID,
+		"sub": s.clientID,
+		"aud": audience,
+		"jti": randomToken(),
+		"iat": now.Unix(),
+		"exp": now.Add(clientAssertionLifetime).Unix(),
+	}
+
+	headerSegment, err := encodeJWTSegment(header)
+	if err != nil {
+		return "", err
+	}
+	claimsSegment, err := encodeJWTSegment(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := headerSegment + "." + claimsSegment
+
+	signature, err := s.sign(signingInput)
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func (s *clientAssertionSigner) sign(signingInput string) ([]byte, error) {
+	digest := sha256.Sum256([]byte(signingInput))
+	switch s.algorithm {
+	case "RS256":
+		rsaKey, ok := s.signer.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("RS256 requires an RSA key, got %T", s.signer)
+		}
+		return rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+	case "PS256":
+		rsaKey, ok := s.signer.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("PS256 requires an RSA key, got %T", s.signer)
+		}
+		return rsa.SignPSS(rand.Reader, rsaKey, crypto.SHA256, digest[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256})
+	case "ES256":
+		ecKey, ok := s.signer.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("ES256 requires an EC key, got %T", s.signer)
+		}
+		r, ss, err := ecdsa.Sign(rand.Reader, ecKey, digest[:])
+		if err != nil {
+			return nil, err
+		}
+		return encodeES256Signature(r, ss, ecKey.Curve.Params().BitSize), nil
+	default:
+		return nil, fmt.Errorf("unsupported clientAssertion algorithm %q", s.algorithm)
+	}
+}
+
+// encodeES256Signature packs (r, s) into the fixed-width big-endian concatenation the JWS spec
+// requires, rather than the ASN.1 DER encoding crypto/ecdsa produces natively.
+func encodeES256Signature(r, s *big.Int, bitSize int) []byte {
+	byteLen := (bitSize + 7) / 8
+	out := make([]byte, 2*byteLen)
+	r.FillBytes(out[:byteLen])
+	s.FillBytes(out[byteLen:])
+	return out
+}
+
+func encodeJWTSegment(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// randomToken returns a random URL-safe identifier, used as a JWT "jti" and (by TokenStore) as a
+// lock's owner/fencing token.
+func randomToken() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
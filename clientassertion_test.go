@@ -0,0 +1,193 @@
+package traefik_outgoing_oauth2_cc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestClientAssertionIsSentInsteadOfBasicAuth(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: mustMarshalPKCS8(t, key)})
+
+	var gotAuthHeader, gotAssertionType, gotAssertion string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_ = req.ParseForm()
+		gotAuthHeader = req.Header.Get("Authorization")
+		gotAssertionType = req.PostForm.Get("client_assertion_type")
+		gotAssertion = req.PostForm.Get("client_assertion")
+		_, _ = rw.Write([]byte(`{"access_token": "test_token", "expires_in": 3600}`))
+	}))
+	defer server.Close()
+
+	cfg := CreateConfig()
+	cfg.AuthGrantRequest.URL = server.URL + "/auth"
+	cfg.AuthGrantRequest.User = "my-client-id"
+	cfg.AuthGrantRequest.ClientAssertion = ClientAssertionConfig{
+		Key:       string(keyPEM),
+		Algorithm: "RS256",
+		KeyID:     "kid-1",
+	}
+
+	handler := newHandler(t, cfg)
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/data", nil)
+	handler.ServeHTTP(recorder, req)
+
+	if gotAuthHeader != "" {
+		t.Errorf("expected no Basic Authorization header, got %q", gotAuthHeader)
+	}
+	if gotAssertionType != clientAssertionType {
+		t.Errorf("expected client_assertion_type %q, got %q", clientAssertionType, gotAssertionType)
+	}
+
+	parts := strings.Split(gotAssertion, ".")
+	if len(parts) != 3 {
+		t.Fatalf("expected a 3-segment JWT, got %d segments", len(parts))
+	}
+	headerBytes, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var header map[string]interface{}
+	if err := json.Unmarshal(headerBytes, &header); err != nil {
+		t.Fatal(err)
+	}
+	if header["kid"] != "kid-1" {
+		t.Errorf("expected kid header 'kid-1', got %v", header["kid"])
+	}
+
+	claimsBytes, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(claimsBytes, &claims); err != nil {
+		t.Fatal(err)
+	}
+	if claims["iss"] != "my-client-id" || claims["sub"] != "my-client-id" {
+		t.Errorf("expected iss/sub 'my-client-id', got iss=%v sub=%v", claims["iss"], claims["sub"])
+	}
+	if claims["aud"] != cfg.AuthGrantRequest.URL {
+		t.Errorf("expected aud to default to the auth-grant URL, got %v", claims["aud"])
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sig) == 0 {
+		t.Error("expected a non-empty signature")
+	}
+}
+
+// TestClientAssertionAudienceResolvesAfterLateDiscovery covers the case where the IdP is
+// unreachable when New runs (so resolveGrantUrl is deferred) and only comes up before the first
+// request: the client-assertion "aud" must pick up the lazily-discovered token endpoint, not an
+// empty string baked in at construction time.
+func TestClientAssertionAudienceResolvesAfterLateDiscovery(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: mustMarshalPKCS8(t, key)})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	if err := ln.Close(); err != nil {
+		t.Fatal(err)
+	}
+	issuerURL := "http://" + addr
+
+	cfg := CreateConfig()
+	cfg.AuthGrantRequest.IssuerURL = issuerURL
+	cfg.AuthGrantRequest.User = "my-client-id"
+	cfg.AuthGrantRequest.ClientAssertion = ClientAssertionConfig{Key: string(keyPEM)}
+
+	handler := newHandler(t, cfg) // New() runs here with the IdP still down; discovery is deferred
+
+	var gotAssertion string
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/.well-known/openid-configuration":
+			_, _ = rw.Write([]byte(`{"token_endpoint": "http://` + req.Host + `/token"}`))
+		case "/token":
+			_ = req.ParseForm()
+			gotAssertion = req.PostForm.Get("client_assertion")
+			_, _ = rw.Write([]byte(`{"access_token": "test_token", "expires_in": 3600}`))
+		default:
+			rw.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	l, err := net.Listen("tcp", addr) // rebind the same address now that the IdP is "up"
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Listener = l
+	server.Start()
+	defer server.Close()
+
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, issuerURL+"/data", nil)
+	handler.ServeHTTP(recorder, req)
+	if recorder.Result().StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", recorder.Result().StatusCode)
+	}
+
+	parts := strings.Split(gotAssertion, ".")
+	if len(parts) != 3 {
+		t.Fatalf("expected a 3-segment JWT, got %d segments", len(parts))
+	}
+	claimsBytes, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(claimsBytes, &claims); err != nil {
+		t.Fatal(err)
+	}
+	wantAudience := issuerURL + "/token"
+	if claims["aud"] != wantAudience {
+		t.Errorf("expected aud to resolve to the discovered token endpoint %q, got %v", wantAudience, claims["aud"])
+	}
+}
+
+func TestClientAssertionWithoutUserFailsFast(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: mustMarshalPKCS8(t, key)})
+
+	cfg := CreateConfig()
+	cfg.AuthGrantRequest.URL = "http://example.invalid/token"
+	cfg.AuthGrantRequest.ClientAssertion = ClientAssertionConfig{Key: string(keyPEM)}
+
+	next := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+	if _, err := New(nil, next, cfg, "test"); err == nil { //nolint:staticcheck
+		t.Error("expected New to fail fast: clientAssertion.key is set without user")
+	}
+}
+
+func mustMarshalPKCS8(t *testing.T, key *rsa.PrivateKey) []byte {
+	t.Helper()
+	b, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
@@ -0,0 +1,92 @@
+package traefik_outgoing_oauth2_cc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// oidcDiscoveryDocument is the subset of the OpenID Connect discovery document
+// (<issuer>/.well-known/openid-configuration) this plugin needs.
+type oidcDiscoveryDocument struct {
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported,omitempty"`
+	GrantTypesSupported               []string `json:"grant_types_supported,omitempty"`
+}
+
+// discoveryCache lazily fetches and caches an issuer's discovery document. A failed fetch is not
+// cached, so the next caller retries instead of being stuck with a permanent startup failure.
+type discoveryCache struct {
+	issuerURL string
+
+	mu  sync.Mutex
+	doc *oidcDiscoveryDocument
+}
+
+func newDiscoveryCache(issuerURL string) *discoveryCache {
+	return &discoveryCache{issuerURL: issuerURL}
+}
+
+func (d *discoveryCache) resolve() (oidcDiscoveryDocument, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.doc != nil {
+		return *d.doc, nil
+	}
+	doc, err := fetchOIDCDiscoveryDocument(d.issuerURL)
+	if err != nil {
+		return oidcDiscoveryDocument{}, err
+	}
+	d.doc = &doc
+	return doc, nil
+}
+
+func fetchOIDCDiscoveryDocument(issuerURL string) (oidcDiscoveryDocument, error) {
+	discoveryURL := strings.TrimSuffix(issuerURL, "/") + "/.well-known/openid-configuration"
+	res, err := http.Get(discoveryURL)
+	if err != nil {
+		return oidcDiscoveryDocument{}, fmt.Errorf("fetch %s: %v", discoveryURL, err)
+	}
+	defer func() { _ = res.Body.Close() }()
+	if res.StatusCode != http.StatusOK {
+		return oidcDiscoveryDocument{}, fmt.Errorf("fetch %s: status-code %d", discoveryURL, res.StatusCode)
+	}
+	var doc oidcDiscoveryDocument
+	if err := json.NewDecoder(res.Body).Decode(&doc); err != nil {
+		return oidcDiscoveryDocument{}, fmt.Errorf("parse %s: %v", discoveryURL, err)
+	}
+	if doc.TokenEndpoint == "" {
+		return oidcDiscoveryDocument{}, fmt.Errorf("%s: response has no token_endpoint", discoveryURL)
+	}
+	return doc, nil
+}
+
+// clientAuthMethod maps this plugin's configured client-authentication style to the
+// token_endpoint_auth_methods_supported value an IdP would advertise for it.
+func clientAuthMethod(hasUser bool, hasClientAssertion bool) string {
+	switch {
+	case hasClientAssertion:
+		return "private_key_jwt"
+	case hasUser:
+		return "client_secret_basic"
+	default:
+		return "none"
+	}
+}
+
+// checkAuthMethodAdvertised fails fast if the issuer's discovery document lists supported client
+// auth methods and the configured one isn't among them. An empty/absent list isn't restrictive.
+func checkAuthMethodAdvertised(doc oidcDiscoveryDocument, method string) error {
+	if len(doc.TokenEndpointAuthMethodsSupported) == 0 {
+		return nil
+	}
+	for _, m := range doc.TokenEndpointAuthMethodsSupported {
+		if m == method {
+			return nil
+		}
+	}
+	return fmt.Errorf("issuer does not advertise support for client auth method %q (supported: %v)",
+		method, doc.TokenEndpointAuthMethodsSupported)
+}
@@ -0,0 +1,35 @@
+package traefik_outgoing_oauth2_cc
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+)
+
+// jwtClaims is the subset of registered claims this plugin cares about in an access token JWT.
+// No signature verification is performed: the token was issued to us by the IdP we just called.
+type jwtClaims struct {
+	Exp   int64  `json:"exp,omitempty"`
+	Nbf   int64  `json:"nbf,omitempty"`
+	Sub   string `json:"sub,omitempty"`
+	Scope string `json:"scope,omitempty"`
+}
+
+// parseJWTClaims decodes the middle (payload) segment of a `.`-separated JWT. It returns
+// ok=false for opaque tokens (not three segments) or a payload that isn't a base64url/JSON claims
+// object, so callers can fall back to expires_in instead of treating this as a hard error.
+func parseJWTClaims(token string) (jwtClaims, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return jwtClaims{}, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return jwtClaims{}, false
+	}
+	var claims jwtClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return jwtClaims{}, false
+	}
+	return claims, true
+}
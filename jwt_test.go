@@ -0,0 +1,62 @@
+package traefik_outgoing_oauth2_cc
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestUseJWTExpiryAndForwardClaims(t *testing.T) {
+	exp := time.Now().Add(2 * time.Hour).Unix()
+	accessToken := makeTestJWT(t, map[string]interface{}{
+		"exp":   exp,
+		"sub":   "subject-123",
+		"scope": "read write",
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_, _ = rw.Write([]byte(fmt.Sprintf(`{"access_token": "%s"}`, accessToken)))
+	}))
+	defer server.Close()
+
+	cfg := CreateConfig()
+	cfg.AuthGrantRequest.URL = server.URL + "/auth"
+	cfg.AuthGrantRequest.UseJWTExpiry = true
+	cfg.AuthGrantRequest.ForwardClaims = []string{"sub", "scope"}
+
+	handler := newHandler(t, cfg)
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/data", nil)
+	handler.ServeHTTP(recorder, req)
+
+	if recorder.Result().StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", recorder.Result().StatusCode)
+	}
+	if req.Header.Get("Authorization") != "Bearer "+accessToken {
+		t.Errorf("unexpected Authorization header: %s", req.Header.Get("Authorization"))
+	}
+	if req.Header.Get("X-OAuth-Subject") != "subject-123" {
+		t.Errorf("expected X-OAuth-Subject 'subject-123', got %q", req.Header.Get("X-OAuth-Subject"))
+	}
+	if req.Header.Get("X-OAuth-Scope") != "read write" {
+		t.Errorf("expected X-OAuth-Scope 'read write', got %q", req.Header.Get("X-OAuth-Scope"))
+	}
+}
+
+func makeTestJWT(t *testing.T, claims map[string]interface{}) string {
+	t.Helper()
+	header, err := json.Marshal(map[string]string{"alg": "none", "typ": "JWT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(header) + "." +
+		base64.RawURLEncoding.EncodeToString(body) + ".sig"
+}
@@ -0,0 +1,104 @@
+package traefik_outgoing_oauth2_cc
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+)
+
+// RouteSelector picks the named Grant to use for a request. All non-empty fields must match; the
+// first matching entry in Config.Routes wins. A request that matches no route falls back to the
+// "default" grant (or, if there is no grant named "default", the lexicographically first one).
+type RouteSelector struct {
+	HostGlob    string      `json:"hostGlob,omitempty"`    // glob against req.Host, e.g. "*.example.com"
+	PathPrefix  string      `json:"pathPrefix,omitempty"`  // prefix match against req.URL.Path
+	HeaderMatch HeaderMatch `json:"headerMatch,omitempty"` // exact match against a request header
+	Grant       string      `json:"grant,omitempty"`       // key into Config.Grants
+}
+
+type HeaderMatch struct {
+	Name  string `json:"name,omitempty"`
+	Value string `json:"value,omitempty"`
+}
+
+func (r RouteSelector) matches(req *http.Request) bool {
+	if r.HostGlob != "" {
+		if ok, err := path.Match(r.HostGlob, req.Host); err != nil || !ok {
+			return false
+		}
+	}
+	if r.PathPrefix != "" && !hasPathPrefix(req.URL.Path, r.PathPrefix) {
+		return false
+	}
+	if r.HeaderMatch.Name != "" && req.Header.Get(r.HeaderMatch.Name) != r.HeaderMatch.Value {
+		return false
+	}
+	return true
+}
+
+func hasPathPrefix(p, prefix string) bool {
+	return len(p) >= len(prefix) && p[:len(prefix)] == prefix
+}
+
+// multiGrant dispatches each request to the OutgoingOAuth2CC instance selected by Routes, so one
+// middleware instance can mint distinct tokens for several upstreams.
+type multiGrant struct {
+	grants       map[string]*OutgoingOAuth2CC
+	routes       []RouteSelector
+	defaultGrant *OutgoingOAuth2CC
+}
+
+func (m *multiGrant) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	grant := m.defaultGrant
+	for _, route := range m.routes {
+		if route.matches(req) {
+			grant = m.grants[route.Grant]
+			break
+		}
+	}
+	grant.ServeHTTP(rw, req)
+}
+
+// newMultiGrant builds the named grants from config.Grants (or config.AuthGrantRequest as the
+// "default" shorthand when config.Grants is empty) and wraps them behind config.Routes.
+func newMultiGrant(next http.Handler, name string, config *Config) (http.Handler, error) {
+	grantConfigs := config.Grants
+	if len(grantConfigs) == 0 {
+		grantConfigs = map[string]AuthGrantRequestConfig{"default": config.AuthGrantRequest}
+	}
+
+	grants := make(map[string]*OutgoingOAuth2CC, len(grantConfigs))
+	for grantName, grantConfig := range grantConfigs {
+		grant, err := newGrantHandler(next, name, config.Trace, config.TokenStore, grantConfig)
+		if err != nil {
+			return nil, fmt.Errorf("outgoing-oauth2-cc grant %q: %v", grantName, err)
+		}
+		grants[grantName] = grant
+	}
+
+	for _, route := range config.Routes {
+		if _, ok := grants[route.Grant]; !ok {
+			return nil, fmt.Errorf("outgoing-oauth2-cc route references unknown grant %q", route.Grant)
+		}
+	}
+
+	return &multiGrant{grants: grants, routes: config.Routes, defaultGrant: defaultGrantOf(grants)}, nil
+}
+
+// defaultGrantOf picks the grant used when no route matches: "default" if present, otherwise the
+// lexicographically first grant name, so the choice is deterministic across runs.
+func defaultGrantOf(grants map[string]*OutgoingOAuth2CC) *OutgoingOAuth2CC {
+	if g, ok := grants["default"]; ok {
+		return g
+	}
+	names := make([]string, 0, len(grants))
+	for name := range grants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		return nil
+	}
+	return grants[names[0]]
+}
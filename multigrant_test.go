@@ -0,0 +1,149 @@
+package traefik_outgoing_oauth2_cc
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// TestRoutesSelectDistinctGrantsPerUpstream configures two named grants and checks that the
+// PathPrefix route sends each upstream its own token instead of a single shared one.
+func TestRoutesSelectDistinctGrantsPerUpstream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_ = req.ParseForm()
+		_, _ = rw.Write([]byte(`{"access_token": "token-` + req.URL.Query().Get("grant") + `", "expires_in": 3600}`))
+	}))
+	defer server.Close()
+
+	cfg := CreateConfig()
+	cfg.Grants = map[string]AuthGrantRequestConfig{
+		"default": {URL: server.URL + "/auth?grant=default"},
+		"billing": {URL: server.URL + "/auth?grant=billing"},
+	}
+	cfg.Routes = []RouteSelector{
+		{PathPrefix: "/billing", Grant: "billing"},
+	}
+
+	handler := newHandler(t, cfg)
+
+	call := func(path string) string {
+		recorder := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, server.URL+path, nil)
+		handler.ServeHTTP(recorder, req)
+		if recorder.Result().StatusCode != http.StatusOK {
+			t.Fatalf("expected 200, got %d", recorder.Result().StatusCode)
+		}
+		return req.Header.Get("Authorization")
+	}
+
+	if got := call("/data"); got != "Bearer token-default" {
+		t.Errorf("expected the default grant's token, got %s", got)
+	}
+	if got := call("/billing/invoice"); got != "Bearer token-billing" {
+		t.Errorf("expected the billing grant's token, got %s", got)
+	}
+}
+
+// TestIssuerURLDiscoversTokenEndpoint checks that IssuerURL is resolved via
+// .well-known/openid-configuration and that an unadvertised client auth method fails New fast.
+func TestIssuerURLDiscoversTokenEndpoint(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/.well-known/openid-configuration":
+			_, _ = rw.Write([]byte(`{"token_endpoint": "http://` + req.Host + `/token", "token_endpoint_auth_methods_supported": ["client_secret_basic"]}`))
+		case "/token":
+			_, _ = rw.Write([]byte(`{"access_token": "discovered-token", "expires_in": 3600}`))
+		default:
+			rw.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	cfg := CreateConfig()
+	cfg.AuthGrantRequest.IssuerURL = server.URL
+	cfg.AuthGrantRequest.User = "client-id"
+	cfg.AuthGrantRequest.Pass = "secret"
+
+	handler := newHandler(t, cfg)
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/data", nil)
+	handler.ServeHTTP(recorder, req)
+
+	if recorder.Result().StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", recorder.Result().StatusCode)
+	}
+	if req.Header.Get("Authorization") != "Bearer discovered-token" {
+		t.Errorf("unexpected Authorization header: %s", req.Header.Get("Authorization"))
+	}
+
+	cfg2 := CreateConfig()
+	cfg2.AuthGrantRequest.IssuerURL = server.URL
+	cfg2.AuthGrantRequest.ClientAssertion.Key = "~direct~dummy"
+	if _, err := New(nil, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}), cfg2, "test"); err == nil { //nolint:staticcheck
+		t.Error("expected New to fail fast: issuer doesn't advertise private_key_jwt")
+	}
+}
+
+// TestConcurrentResolveGrantUrlAfterLateDiscovery fires a burst of concurrent requests against a
+// grant whose IssuerURL was unreachable at startup (so resolveGrantUrl's lazy retry races between
+// goroutines once discovery succeeds). Run with -race: it must not report a data race on
+// authGrantUrl, and every request must still end up with the discovered token.
+func TestConcurrentResolveGrantUrlAfterLateDiscovery(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	if err := ln.Close(); err != nil {
+		t.Fatal(err)
+	}
+	issuerURL := "http://" + addr
+
+	cfg := CreateConfig()
+	cfg.AuthGrantRequest.IssuerURL = issuerURL
+
+	handler := newHandler(t, cfg) // New() runs here with the IdP still down; discovery is deferred
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/.well-known/openid-configuration":
+			_, _ = rw.Write([]byte(`{"token_endpoint": "http://` + req.Host + `/token"}`))
+		case "/token":
+			_, _ = rw.Write([]byte(`{"access_token": "discovered-token", "expires_in": 3600}`))
+		default:
+			rw.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	l, err := net.Listen("tcp", addr) // rebind the same address now that the IdP is "up"
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.Listener = l
+	server.Start()
+	defer server.Close()
+
+	const concurrency = 20
+	var wg sync.WaitGroup
+	wg.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			defer wg.Done()
+			recorder := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodGet, issuerURL+"/data", nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			handler.ServeHTTP(recorder, req)
+			if recorder.Result().StatusCode != http.StatusOK {
+				t.Errorf("http status mismatch: expected %d != %d actual", http.StatusOK, recorder.Result().StatusCode)
+			}
+			if got := req.Header.Get("Authorization"); got != "Bearer discovered-token" {
+				t.Errorf("unexpected Authorization header: %s", got)
+			}
+		}()
+	}
+	wg.Wait()
+}
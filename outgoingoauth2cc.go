@@ -11,23 +11,37 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // Config Plugin configuration.
 type Config struct {
-	AuthGrantRequest AuthGrantRequestConfig `json:"authGrantRequest,omitempty"`
-	Trace            bool                   `json:"trace,omitempty"` // additional logging
+	AuthGrantRequest AuthGrantRequestConfig            `json:"authGrantRequest,omitempty"` // shorthand for Grants["default"], used when Grants is empty
+	Grants           map[string]AuthGrantRequestConfig `json:"grants,omitempty"`           // named upstream token configurations, selected via Routes
+	Routes           []RouteSelector                   `json:"routes,omitempty"`           // matched in order; the first match selects the grant for a request
+	TokenStore       TokenStoreConfig                  `json:"tokenStore,omitempty"`       // shared token cache for multi-replica deployments, defaults to in-process-only
+	Trace            bool                              `json:"trace,omitempty"`            // additional logging
 }
 
 type AuthGrantRequestConfig struct {
-	URL                   string   `json:"url,omitempty"` // the URL to request the token
-	User                  string   `json:"user,omitempty"`
-	Pass                  string   `json:"pass,omitempty"`
-	Scope                 string   `json:"scope,omitempty"`
-	Headers               []Header `json:"headers,omitempty"`
-	ExpiresMarginSeconds  int      `json:"expiresMarginSeconds,omitempty"`  // the margin in seconds to subtract from the expires_in value
-	BasicAuthSkipEncoding bool     `json:"basicAuthSkipEncoding,omitempty"` // skip url-encoding of user and pass for basic auth
+	URL                   string                `json:"url,omitempty"`       // the URL to request the token
+	IssuerURL             string                `json:"issuerURL,omitempty"` // alternative to URL: discover the token endpoint via OIDC discovery
+	User                  string                `json:"user,omitempty"`
+	Pass                  string                `json:"pass,omitempty"`
+	Scope                 string                `json:"scope,omitempty"`
+	Headers               []Header              `json:"headers,omitempty"`
+	ExpiresMarginSeconds  int                   `json:"expiresMarginSeconds,omitempty"`  // the margin in seconds to subtract from the expires_in value
+	BasicAuthSkipEncoding bool                  `json:"basicAuthSkipEncoding,omitempty"` // skip url-encoding of user and pass for basic auth
+	BackgroundRefresh     bool                  `json:"backgroundRefresh,omitempty"`     // proactively refresh the token in the background instead of blocking a request once it is due
+	RefreshAheadFactor    float64               `json:"refreshAheadFactor,omitempty"`    // fraction of the token lifetime after which a background refresh is triggered, defaults to 0.5
+	Retry                 RetryConfig           `json:"retry,omitempty"`
+	ClientAssertion       ClientAssertionConfig `json:"clientAssertion,omitempty"` // RFC 7523 private_key_jwt authentication, used instead of Basic auth when set
+	UseJWTExpiry          bool                  `json:"useJWTExpiry,omitempty"`    // derive the cache lifetime from the access token's own "exp" claim instead of expires_in
+	ForwardClaims         []string              `json:"forwardClaims,omitempty"`   // claims ("sub", "scope") to forward to the upstream as X-OAuth-* request headers; only takes effect with useJWTExpiry, which is what parses the token
+	Mode                  string                `json:"mode,omitempty"`            // "client_credentials" (default) or "token_exchange"
+	TokenExchange         TokenExchangeConfig   `json:"tokenExchange,omitempty"`
 }
 
 type Header struct {
@@ -35,11 +49,18 @@ type Header struct {
 	Value string `json:"value,omitempty"`
 }
 
+// state is the cached token together with the bookkeeping needed to decide when it needs to be replaced.
+// obtained is the time the token was issued, used to derive the background-refresh deadline from expires.
 type state struct {
-	token   string
-	expires time.Time
+	token    string
+	expires  time.Time
+	obtained time.Time
+	subject  string // from the "sub" claim, set when useJWTExpiry parsed the access token as a JWT
+	scope    string // from the "scope" claim, set when useJWTExpiry parsed the access token as a JWT
 }
 
+const defaultRefreshAheadFactor = 0.5
+
 // CreateConfig creates the default plugin configuration.
 func CreateConfig() *Config {
 	return &Config{}
@@ -50,17 +71,45 @@ type OutgoingOAuth2CC struct {
 	next                          http.Handler
 	name                          string
 	trace                         bool
-	authGrantUrl                  string
+	authGrantUrl                  string // empty until resolveGrantUrl succeeds, when discovery is still pending
+	urlMu                         sync.RWMutex
+	discovery                     *discoveryCache // non-nil when IssuerURL is configured in place of URL
+	discoveryAuthMethod           string
 	authGrantScope                string
 	authGrantHeaders              map[string]string
 	authGrantExpiresMarginSeconds int64
-	state                         state
+	backgroundRefresh             bool
+	refreshAheadFactor            float64
+	retry                         *retrier
+	clientAssertion               *clientAssertionSigner
+	useJWTExpiry                  bool
+	forwardClaims                 []string
+	tokenStore                    TokenStore
+	tokenStoreKey                 string
+	mode                          string
+	exchangeAudience              string
+	exchangeResource              string
+	exchangeRequestedTokenType    string
+	fallbackToClientCredentials   bool
+	exchangeCache                 *lruCache
+
+	stateMu    sync.RWMutex // guards state, read on every request, written once per token acquisition
+	state      state
+	fetchMu    sync.Mutex // serializes auth-grant requests so concurrent callers coalesce onto a single in-flight fetch
+	refreshing int32      // atomic flag, set while a background refresh goroutine is in flight
 }
 
-// New plugin instance.
+// New plugin instance. It builds one grant handler per entry of config.Grants (or a single
+// "default" one as shorthand from config.AuthGrantRequest when Grants is empty) and wraps them
+// behind the Routes selector, so one middleware instance can mint distinct tokens per upstream.
 func New(_ context.Context, next http.Handler, config *Config, name string) (http.Handler, error) {
+	return newMultiGrant(next, name, config)
+}
+
+// newGrantHandler builds the single-grant auth-grant engine for one named entry of config.Grants.
+func newGrantHandler(next http.Handler, name string, trace bool, tokenStoreCfg TokenStoreConfig, grantConfig AuthGrantRequestConfig) (*OutgoingOAuth2CC, error) {
 	authGrantHeaders := make(map[string]string)
-	for _, header := range config.AuthGrantRequest.Headers {
+	for _, header := range grantConfig.Headers {
 		hdrName, err := fromFlexibleField("header.name", header.Name)
 		if err != nil {
 			return nil, err
@@ -72,55 +121,302 @@ func New(_ context.Context, next http.Handler, config *Config, name string) (htt
 		authGrantHeaders[hdrName] = hdrValue
 	}
 
-	user, err := fromFlexibleField("user", config.AuthGrantRequest.User)
+	user, err := fromFlexibleField("user", grantConfig.User)
 	if err != nil {
 		return nil, err
 	}
-	if user != "" {
-		pass, err := fromFlexibleField("pass", config.AuthGrantRequest.Pass)
+	if user != "" && grantConfig.ClientAssertion.Key == "" {
+		pass, err := fromFlexibleField("pass", grantConfig.Pass)
 		if err != nil {
 			return nil, err
 		}
-		basicAuthSkipEncoding := config.AuthGrantRequest.BasicAuthSkipEncoding
+		basicAuthSkipEncoding := grantConfig.BasicAuthSkipEncoding
 		basicAuth := queryEncode(user, basicAuthSkipEncoding) + ":" + queryEncode(pass, basicAuthSkipEncoding)
 		authGrantHeaders["Authorization"] = "Basic " + base64.StdEncoding.EncodeToString([]byte(basicAuth))
 	}
 
-	grantUrl, err := fromFlexibleField("url", config.AuthGrantRequest.URL)
+	grantUrl, err := fromFlexibleField("url", grantConfig.URL)
 	if err != nil {
 		return nil, err
 	}
-	if grantUrl == "" {
-		return nil, fmt.Errorf("outgoing-oauth2-cc missing value for url")
+
+	discoveryAuthMethod := clientAuthMethod(user != "", grantConfig.ClientAssertion.Key != "")
+	var discovery *discoveryCache
+	if grantUrl == "" && grantConfig.IssuerURL != "" {
+		issuerURL, err := fromFlexibleField("issuerURL", grantConfig.IssuerURL)
+		if err != nil {
+			return nil, err
+		}
+		discovery = newDiscoveryCache(issuerURL)
+		if doc, err := discovery.resolve(); err == nil {
+			if err := checkAuthMethodAdvertised(doc, discoveryAuthMethod); err != nil {
+				return nil, fmt.Errorf("outgoing-oauth2-cc issuerURL %s: %v", issuerURL, err)
+			}
+			grantUrl = doc.TokenEndpoint
+		}
+		// else: the IdP wasn't reachable at startup; resolveGrantUrl retries lazily on first use.
+	}
+	if grantUrl == "" && discovery == nil {
+		return nil, fmt.Errorf("outgoing-oauth2-cc missing value for url (or issuerURL)")
+	}
+
+	var clientAssertion *clientAssertionSigner
+	if grantConfig.ClientAssertion.Key != "" {
+		if user == "" {
+			return nil, fmt.Errorf("outgoing-oauth2-cc missing value for user (required when clientAssertion.key is set)")
+		}
+		clientAssertion, err = newClientAssertionSigner(grantConfig.ClientAssertion, user)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	refreshAheadFactor := grantConfig.RefreshAheadFactor
+	if refreshAheadFactor <= 0 {
+		refreshAheadFactor = defaultRefreshAheadFactor
+	}
+
+	tokenStore, err := newTokenStore(tokenStoreCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	mode := grantConfig.Mode
+	if mode == "" {
+		mode = modeClientCredentials
+	}
+	if mode != modeClientCredentials && mode != modeTokenExchange {
+		return nil, fmt.Errorf("outgoing-oauth2-cc unknown mode: %s", mode)
 	}
 
 	return &OutgoingOAuth2CC{
 		next:                          next,
 		name:                          name,
-		trace:                         config.Trace,
+		trace:                         trace,
 		authGrantUrl:                  grantUrl,
-		authGrantScope:                config.AuthGrantRequest.Scope,
+		discovery:                     discovery,
+		discoveryAuthMethod:           discoveryAuthMethod,
+		authGrantScope:                grantConfig.Scope,
 		authGrantHeaders:              authGrantHeaders,
-		authGrantExpiresMarginSeconds: maxInt(1, int64(config.AuthGrantRequest.ExpiresMarginSeconds)),
-		state: state{
-			token:   "",
-			expires: time.Time{},
-		},
+		authGrantExpiresMarginSeconds: maxInt(1, int64(grantConfig.ExpiresMarginSeconds)),
+		backgroundRefresh:             grantConfig.BackgroundRefresh,
+		refreshAheadFactor:            refreshAheadFactor,
+		retry:                         newRetrier(grantConfig.Retry),
+		clientAssertion:               clientAssertion,
+		useJWTExpiry:                  grantConfig.UseJWTExpiry,
+		forwardClaims:                 grantConfig.ForwardClaims,
+		tokenStore:                    tokenStore,
+		tokenStoreKey:                 tokenStoreKey(tokenStoreCfg.KeyPrefix, grantUrl, user, grantConfig.Scope),
+		mode:                          mode,
+		exchangeAudience:              grantConfig.TokenExchange.Audience,
+		exchangeResource:              grantConfig.TokenExchange.Resource,
+		exchangeRequestedTokenType:    grantConfig.TokenExchange.RequestedTokenType,
+		fallbackToClientCredentials:   grantConfig.TokenExchange.FallbackToClientCredentials,
+		exchangeCache:                 newLRUCache(grantConfig.TokenExchange.CacheSize),
 	}, nil
 }
 
+// resolveGrantUrl returns the auth-grant URL, retrying OIDC discovery if it failed at startup. A
+// failed resolve is not cached, so the next request tries again instead of failing forever.
+// authGrantUrl is read and written under urlMu throughout, the same pattern fetchToken/token use
+// around state via stateMu.
+func (c *OutgoingOAuth2CC) resolveGrantUrl() (string, error) {
+	c.urlMu.RLock()
+	url := c.authGrantUrl
+	c.urlMu.RUnlock()
+	if url != "" {
+		return url, nil
+	}
+	c.urlMu.Lock()
+	defer c.urlMu.Unlock()
+	if c.authGrantUrl != "" {
+		return c.authGrantUrl, nil
+	}
+	doc, err := c.discovery.resolve()
+	if err != nil {
+		return "", fmt.Errorf("oidc discovery: %v", err)
+	}
+	if err := checkAuthMethodAdvertised(doc, c.discoveryAuthMethod); err != nil {
+		return "", fmt.Errorf("oidc discovery: %v", err)
+	}
+	c.authGrantUrl = doc.TokenEndpoint
+	return c.authGrantUrl, nil
+}
+
 func (c *OutgoingOAuth2CC) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
-	if time.Now().After(c.state.expires) {
-		data := url.Values{
-			"grant_type": {"client_credentials"},
+	var st state
+	var err error
+	if c.mode == modeTokenExchange {
+		st, err = c.tokenForExchange(req)
+	} else {
+		st, err = c.token()
+	}
+	if err != nil {
+		if statusErr, ok := err.(httpStatusError); ok {
+			serveError(rw, statusErr.msg, statusErr.statusCode)
+		} else {
+			serveInternalError(rw, err.Error())
+		}
+		return
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", st.token))
+	for _, claim := range c.forwardClaims {
+		switch claim {
+		case "sub":
+			if st.subject != "" {
+				req.Header.Set("X-OAuth-Subject", st.subject)
+			}
+		case "scope":
+			if st.scope != "" {
+				req.Header.Set("X-OAuth-Scope", st.scope)
+			}
+		}
+	}
+	c.next.ServeHTTP(rw, req)
+}
+
+// token returns a valid cached token, transparently fetching or coalescing onto an in-flight fetch as needed.
+func (c *OutgoingOAuth2CC) token() (state, error) {
+	c.stateMu.RLock()
+	st := c.state
+	c.stateMu.RUnlock()
+
+	if time.Now().Before(st.expires) {
+		c.maybeRefreshAhead(st)
+		return st, nil
+	}
+	return c.fetchToken(false)
+}
+
+// maybeRefreshAhead kicks off a single background refresh once refreshAheadFactor of the token
+// lifetime has elapsed, so the next expiry is served from cache instead of blocking on the IdP.
+func (c *OutgoingOAuth2CC) maybeRefreshAhead(st state) {
+	if !c.backgroundRefresh || st.obtained.IsZero() {
+		return
+	}
+	refreshAt := st.obtained.Add(time.Duration(float64(st.expires.Sub(st.obtained)) * c.refreshAheadFactor))
+	if time.Now().Before(refreshAt) {
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&c.refreshing, 0, 1) {
+		return // a refresh is already in flight
+	}
+	go func() {
+		defer atomic.StoreInt32(&c.refreshing, 0)
+		if _, err := c.fetchToken(true); err != nil && c.trace {
+			_, _ = os.Stderr.WriteString(fmt.Sprintf("outgoing-oauth2-cc background refresh failed: %v\n", err))
+		}
+	}()
+}
+
+// fetchToken performs the actual auth-grant request, coalescing concurrent callers onto a single
+// request via fetchMu: everyone but the first blocks here and then observes the result it fetched.
+// When a TokenStore is configured, it is consulted (and locked) before falling back to the IdP, so
+// replicas sharing the store negotiate at most one token between them.
+// force (used by the background refresh) fetches even though the cached token is still valid,
+// and only accepts a shared token that expires later than the cached one.
+func (c *OutgoingOAuth2CC) fetchToken(force bool) (state, error) {
+	c.fetchMu.Lock()
+	defer c.fetchMu.Unlock()
+
+	c.stateMu.RLock()
+	st := c.state
+	c.stateMu.RUnlock()
+	if !force && time.Now().Before(st.expires) {
+		return st, nil // someone else already refreshed while we waited for fetchMu
+	}
+
+	storeReachable := c.tokenStore != nil
+	if storeReachable {
+		if shared, ok := c.sharedToken(); ok && shared.expires.After(st.expires) {
+			return shared, nil
+		}
+		unlock, err := c.tokenStore.Lock(c.tokenStoreKey, tokenStoreLockTTL)
+		if err != nil {
+			// The shared store is unavailable (e.g. Redis/NFS hiccup): fall back to fetching
+			// directly from the IdP rather than turning a transient store outage into a hard
+			// failure for every replica. A duplicate IdP hit across replicas is an acceptable cost.
+			if c.trace {
+				_, _ = os.Stderr.WriteString(fmt.Sprintf("outgoing-oauth2-cc token-store lock failed, falling back to a direct fetch: %v\n", err))
+			}
+			storeReachable = false
+		} else {
+			defer unlock()
+			if shared, ok := c.sharedToken(); ok && shared.expires.After(st.expires) { // another replica may have refreshed while we waited for the lock
+				return shared, nil
+			}
+		}
+	}
+
+	st, err := c.requestToken()
+	if err != nil {
+		return state{}, err
+	}
+
+	c.stateMu.Lock()
+	c.state = st
+	c.stateMu.Unlock()
+
+	if storeReachable {
+		if err := c.tokenStore.Set(c.tokenStoreKey, st, time.Until(st.expires)); err != nil && c.trace {
+			_, _ = os.Stderr.WriteString(fmt.Sprintf("outgoing-oauth2-cc token-store set failed: %v\n", err))
 		}
-		if c.authGrantScope != "" {
-			data.Set("scope", c.authGrantScope)
+	}
+	return st, nil
+}
+
+// sharedToken checks the TokenStore for a still-valid token cached by another replica, adopting
+// it into the local state if found.
+func (c *OutgoingOAuth2CC) sharedToken() (state, bool) {
+	shared, ok, err := c.tokenStore.Get(c.tokenStoreKey)
+	if err != nil {
+		if c.trace {
+			_, _ = os.Stderr.WriteString(fmt.Sprintf("outgoing-oauth2-cc token-store get failed: %v\n", err))
 		}
-		agReq, err := http.NewRequest("POST", c.authGrantUrl, strings.NewReader(data.Encode()))
+		return state{}, false
+	}
+	if !ok || !time.Now().Before(shared.expires) {
+		return state{}, false
+	}
+	c.stateMu.Lock()
+	c.state = shared
+	c.stateMu.Unlock()
+	return shared, true
+}
+
+// requestToken performs the client_credentials auth-grant request.
+func (c *OutgoingOAuth2CC) requestToken() (state, error) {
+	data := url.Values{
+		"grant_type": {modeClientCredentials},
+	}
+	if c.authGrantScope != "" {
+		data.Set("scope", c.authGrantScope)
+	}
+	return c.performAuthGrant(data)
+}
+
+// performAuthGrant performs the auth-grant HTTP round trip (through the retrier) for the given
+// form data, applying client authentication (client assertion, or Basic via authGrantHeaders) and
+// parsing the response into a new state. Shared by the client_credentials and token_exchange grants.
+func (c *OutgoingOAuth2CC) performAuthGrant(data url.Values) (state, error) {
+	grantUrl, err := c.resolveGrantUrl()
+	if err != nil {
+		return state{}, err
+	}
+
+	if c.clientAssertion != nil {
+		assertion, err := c.clientAssertion.assertion(grantUrl)
 		if err != nil {
-			serveInternalError(rw, fmt.Sprintf("new-request: %v", err))
-			return
+			return state{}, fmt.Errorf("client-assertion: %v", err)
+		}
+		data.Set("client_assertion_type", clientAssertionType)
+		data.Set("client_assertion", assertion)
+	}
+
+	attempt := func() (*http.Response, []byte, error) {
+		agReq, err := http.NewRequest("POST", grantUrl, strings.NewReader(data.Encode()))
+		if err != nil {
+			return nil, nil, err
 		}
 		agReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 		agReq.Header.Set("Accept", "*/*")
@@ -129,54 +425,89 @@ func (c *OutgoingOAuth2CC) ServeHTTP(rw http.ResponseWriter, req *http.Request)
 		}
 		agRes, err := http.DefaultClient.Do(agReq)
 		if err != nil {
-			serveInternalError(rw, fmt.Sprintf("do-request: %v", err))
-			return
+			return nil, nil, err
 		}
 		defer func(Body io.ReadCloser) {
 			_ = Body.Close()
 		}(agRes.Body)
-
 		body, _ := io.ReadAll(agRes.Body)
-		if c.trace {
-			_, _ = os.Stdout.WriteString(fmt.Sprintf("outgoing-oauth2-cc auth-grant response: %s\n", body))
-		}
+		return agRes, body, nil
+	}
 
-		if agRes.StatusCode != 200 {
-			serveError(rw, fmt.Sprintf("status-code: %d", agRes.StatusCode), agRes.StatusCode)
-			return
+	agRes, body, err := c.retry.do(attempt)
+	if err != nil {
+		if statusErr, ok := err.(httpStatusError); ok {
+			return state{}, statusErr
 		}
+		return state{}, fmt.Errorf("do-request: %v", err)
+	}
 
-		var responseData map[string]interface{}
-		err = json.Unmarshal(body, &responseData)
-		if err != nil {
-			serveInternalError(rw, fmt.Sprintf("unmarshall: %v", err))
-			return
-		}
-		accessToken, ok := responseData["access_token"].(string)
-		if !ok {
-			serveInternalError(rw, fmt.Sprintf("access_token not found"))
-			return
-		}
-		expiresInStr, okStr := responseData["expires_in"].(string)
-		expiresInFlt, okFlt := responseData["expires_in"].(float64)
-		if !okFlt && okStr {
-			expiresInFlt, err = strconv.ParseFloat(expiresInStr, 64)
-		}
-		if !okFlt && err != nil {
-			serveInternalError(rw, fmt.Sprintf("expires_in not found or not parseable"))
-			return
-		}
-		expiresIn := int64(expiresInFlt)
-		expiresInAdjusted := maxInt(1, expiresIn-c.authGrantExpiresMarginSeconds)
-		c.state.token = accessToken
-		c.state.expires = time.Now().Truncate(time.Second).Add(time.Duration(expiresInAdjusted) * time.Second)
-		if c.trace {
-			_, _ = os.Stdout.WriteString(fmt.Sprintf("outgoing-oauth2-cc: token=%s  expires=%d  adjusted=%d\n",
-				c.state.token, expiresIn, expiresInAdjusted))
-		}
+	if c.trace {
+		_, _ = os.Stdout.WriteString(fmt.Sprintf("outgoing-oauth2-cc auth-grant response: %s\n", body))
 	}
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.state.token))
-	c.next.ServeHTTP(rw, req)
+
+	if agRes.StatusCode != 200 {
+		return state{}, httpStatusError{statusCode: agRes.StatusCode, msg: fmt.Sprintf("status-code: %d", agRes.StatusCode)}
+	}
+
+	var responseData map[string]interface{}
+	err = json.Unmarshal(body, &responseData)
+	if err != nil {
+		return state{}, fmt.Errorf("unmarshall: %v", err)
+	}
+	accessToken, ok := responseData["access_token"].(string)
+	if !ok {
+		return state{}, fmt.Errorf("access_token not found")
+	}
+	expiresInStr, okStr := responseData["expires_in"].(string)
+	expiresInFlt, okFlt := responseData["expires_in"].(float64)
+	if !okFlt && okStr {
+		expiresInFlt, err = strconv.ParseFloat(expiresInStr, 64)
+	}
+	haveExpiresIn := okFlt || (okStr && err == nil)
+
+	var claims jwtClaims
+	var haveClaims bool
+	if c.useJWTExpiry {
+		claims, haveClaims = parseJWTClaims(accessToken)
+	}
+
+	var expiresIn int64
+	switch {
+	case haveClaims && claims.Exp > 0:
+		expiresIn = claims.Exp - time.Now().Unix()
+	case haveExpiresIn:
+		expiresIn = int64(expiresInFlt)
+	default:
+		return state{}, fmt.Errorf("expires_in not found or not parseable")
+	}
+	expiresInAdjusted := maxInt(1, expiresIn-c.authGrantExpiresMarginSeconds)
+	now := time.Now().Truncate(time.Second)
+	st := state{
+		token:    accessToken,
+		obtained: now,
+		expires:  now.Add(time.Duration(expiresInAdjusted) * time.Second),
+	}
+	if haveClaims {
+		st.subject = claims.Sub
+		st.scope = claims.Scope
+	}
+	if c.trace {
+		_, _ = os.Stdout.WriteString(fmt.Sprintf("outgoing-oauth2-cc: token=%s  expires=%d  adjusted=%d\n",
+			st.token, expiresIn, expiresInAdjusted))
+	}
+	return st, nil
+}
+
+// httpStatusError carries the auth-grant endpoint's status code through requestToken/fetchToken
+// so ServeHTTP can reflect it to the caller instead of collapsing every failure to 500.
+type httpStatusError struct {
+	statusCode int
+	msg        string
+}
+
+func (e httpStatusError) Error() string {
+	return e.msg
 }
 
 func serveError(rw http.ResponseWriter, msg string, status int) {
@@ -7,6 +7,8 @@ import (
 	"net/http/httptest"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 )
 
@@ -78,6 +80,51 @@ func testCall(t *testing.T, ctx context.Context, server *httptest.Server, handle
 	}
 }
 
+// TestConcurrentSingleFlight fires a burst of concurrent requests against an expired token and
+// asserts the auth-grant endpoint is only hit once: the rest must coalesce onto that one fetch.
+func TestConcurrentSingleFlight(t *testing.T) {
+	var authCalls int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&authCalls, 1)
+		_, _ = rw.Write([]byte(`{"access_token": "test_token", "expires_in": 3600}`))
+	}))
+	defer server.Close()
+
+	cfg := CreateConfig()
+	cfg.AuthGrantRequest.URL = server.URL + "/auth"
+
+	ctx := context.Background()
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	handler, err := New(ctx, next, cfg, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const concurrency = 20
+	var wg sync.WaitGroup
+	wg.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			defer wg.Done()
+			recorder := httptest.NewRecorder()
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/data", nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			handler.ServeHTTP(recorder, req)
+			if recorder.Result().StatusCode != http.StatusOK {
+				t.Errorf("http status mismatch: expected %d != %d actual", http.StatusOK, recorder.Result().StatusCode)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if authCalls != 1 {
+		t.Errorf("expected exactly 1 auth-grant request, got %d", authCalls)
+	}
+}
+
 //goland:noinspection GoUnhandledErrorResult
 func TestFromFlexibleField(t *testing.T) {
 	tmpdir := t.TempDir()
@@ -0,0 +1,180 @@
+package traefik_outgoing_oauth2_cc
+
+import (
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RetryConfig controls the retry/backoff and circuit-breaker behavior around auth-grant requests.
+type RetryConfig struct {
+	Enabled                       bool    `json:"enabled,omitempty"`
+	MinDelayMilliseconds          int     `json:"minDelayMilliseconds,omitempty"`          // default 500
+	MaxDelayMilliseconds          int     `json:"maxDelayMilliseconds,omitempty"`          // default 30000
+	Multiplier                    float64 `json:"multiplier,omitempty"`                    // default 2
+	MaxRetries                    int     `json:"maxRetries,omitempty"`                    // default 5
+	CircuitBreakerThreshold       int     `json:"circuitBreakerThreshold,omitempty"`       // consecutive failures before short-circuiting; 0 disables the breaker
+	CircuitBreakerCooldownSeconds int     `json:"circuitBreakerCooldownSeconds,omitempty"` // default 30
+}
+
+const (
+	defaultRetryMinDelay          = 500 * time.Millisecond
+	defaultRetryMaxDelay          = 30 * time.Second
+	defaultRetryMultiplier        = 2.0
+	defaultRetryMaxRetries        = 5
+	defaultCircuitBreakerCooldown = 30 * time.Second
+)
+
+// retrier wraps the auth-grant HTTP round trip with exponential backoff (full jitter, honoring
+// Retry-After on 429/503) and a trivial circuit breaker, so a flaky or overloaded IdP degrades
+// into bounded retries and a fast 503 instead of a synchronous failure or a retry stampede.
+type retrier struct {
+	enabled    bool
+	minDelay   time.Duration
+	maxDelay   time.Duration
+	multiplier float64
+	maxRetries int
+
+	breakerThreshold int
+	breakerCooldown  time.Duration
+
+	mu                  sync.Mutex
+	consecutiveFailures int
+	openUntil           time.Time
+}
+
+func newRetrier(cfg RetryConfig) *retrier {
+	minDelay := time.Duration(cfg.MinDelayMilliseconds) * time.Millisecond
+	if minDelay <= 0 {
+		minDelay = defaultRetryMinDelay
+	}
+	maxDelay := time.Duration(cfg.MaxDelayMilliseconds) * time.Millisecond
+	if maxDelay <= 0 {
+		maxDelay = defaultRetryMaxDelay
+	}
+	multiplier := cfg.Multiplier
+	if multiplier <= 0 {
+		multiplier = defaultRetryMultiplier
+	}
+	maxRetries := cfg.MaxRetries
+	if maxRetries <= 0 {
+		maxRetries = defaultRetryMaxRetries
+	}
+	cooldown := time.Duration(cfg.CircuitBreakerCooldownSeconds) * time.Second
+	if cooldown <= 0 {
+		cooldown = defaultCircuitBreakerCooldown
+	}
+	return &retrier{
+		enabled:          cfg.Enabled,
+		minDelay:         minDelay,
+		maxDelay:         maxDelay,
+		multiplier:       multiplier,
+		maxRetries:       maxRetries,
+		breakerThreshold: cfg.CircuitBreakerThreshold,
+		breakerCooldown:  cooldown,
+	}
+}
+
+// do runs attempt, retrying retryable failures with exponential backoff until it succeeds,
+// exhausts maxRetries, or failing fast with httpStatusError if the circuit breaker is open.
+func (r *retrier) do(attempt func() (*http.Response, []byte, error)) (*http.Response, []byte, error) {
+	if r.circuitOpen() {
+		return nil, nil, httpStatusError{statusCode: http.StatusServiceUnavailable, msg: "circuit breaker open, failing fast"}
+	}
+
+	maxTries := 1
+	if r.enabled {
+		maxTries = r.maxRetries + 1
+	}
+
+	var res *http.Response
+	var body []byte
+	var err error
+	for try := 0; try < maxTries; try++ {
+		res, body, err = attempt()
+		failed := isRetryableFailure(res, err)
+		r.recordResult(failed)
+		if !failed || try == maxTries-1 {
+			break
+		}
+		time.Sleep(r.delay(try, res))
+	}
+	return res, body, err
+}
+
+// circuitOpen reports whether the breaker is currently short-circuiting requests.
+func (r *retrier) circuitOpen() bool {
+	if r.breakerThreshold <= 0 {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return time.Now().Before(r.openUntil)
+}
+
+func (r *retrier) recordResult(failed bool) {
+	if r.breakerThreshold <= 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !failed {
+		r.consecutiveFailures = 0
+		return
+	}
+	r.consecutiveFailures++
+	if r.consecutiveFailures >= r.breakerThreshold {
+		r.openUntil = time.Now().Add(r.breakerCooldown)
+	}
+}
+
+// delay computes the wait before the next attempt, preferring a server-provided Retry-After on
+// 429/503 and otherwise falling back to exponential backoff with full jitter.
+func (r *retrier) delay(try int, res *http.Response) time.Duration {
+	if res != nil && (res.StatusCode == http.StatusTooManyRequests || res.StatusCode == http.StatusServiceUnavailable) {
+		if d, ok := retryAfterDelay(res); ok {
+			return d
+		}
+	}
+	backoff := float64(r.minDelay) * math.Pow(r.multiplier, float64(try))
+	if backoff > float64(r.maxDelay) {
+		backoff = float64(r.maxDelay)
+	}
+	return time.Duration(rand.Float64() * backoff)
+}
+
+// retryAfterDelay parses the Retry-After header, which may be either a number of seconds or an
+// HTTP date, per RFC 7231 7.1.3.
+func retryAfterDelay(res *http.Response) (time.Duration, bool) {
+	v := res.Header.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d, true
+		}
+	}
+	return 0, false
+}
+
+// isRetryableFailure classifies which failures are worth retrying: connection errors, 5xx, 408
+// (request timeout) and 429 (rate-limited). Anything else (4xx auth/config errors) is permanent.
+func isRetryableFailure(res *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	if res == nil {
+		return false
+	}
+	if res.StatusCode == http.StatusRequestTimeout || res.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	return res.StatusCode >= 500
+}
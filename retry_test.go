@@ -0,0 +1,119 @@
+package traefik_outgoing_oauth2_cc
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRetrySucceedsAfterTransientFailures(t *testing.T) {
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		if calls < 3 {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = rw.Write([]byte(`{"access_token": "test_token", "expires_in": 3600}`))
+	}))
+	defer server.Close()
+
+	cfg := CreateConfig()
+	cfg.AuthGrantRequest.URL = server.URL + "/auth"
+	cfg.AuthGrantRequest.Retry = RetryConfig{
+		Enabled:              true,
+		MinDelayMilliseconds: 1,
+		MaxDelayMilliseconds: 2,
+		MaxRetries:           5,
+	}
+
+	handler := newHandler(t, cfg)
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/data", nil)
+	handler.ServeHTTP(recorder, req)
+
+	if recorder.Result().StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d after %d calls", recorder.Result().StatusCode, calls)
+	}
+	if calls != 3 {
+		t.Errorf("expected 3 auth-grant calls, got %d", calls)
+	}
+}
+
+func TestRetryGivesUpAfterMaxRetries(t *testing.T) {
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	cfg := CreateConfig()
+	cfg.AuthGrantRequest.URL = server.URL + "/auth"
+	cfg.AuthGrantRequest.Retry = RetryConfig{
+		Enabled:              true,
+		MinDelayMilliseconds: 1,
+		MaxDelayMilliseconds: 2,
+		MaxRetries:           2,
+	}
+
+	handler := newHandler(t, cfg)
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/data", nil)
+	handler.ServeHTTP(recorder, req)
+
+	if recorder.Result().StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected 503, got %d", recorder.Result().StatusCode)
+	}
+	if calls != 3 { // initial attempt + 2 retries
+		t.Errorf("expected 3 auth-grant calls, got %d", calls)
+	}
+}
+
+func TestCircuitBreakerOpensAndFailsFast(t *testing.T) {
+	var calls int
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		calls++
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	cfg := CreateConfig()
+	cfg.AuthGrantRequest.URL = server.URL + "/auth"
+	cfg.AuthGrantRequest.Retry = RetryConfig{
+		Enabled:                       false,
+		CircuitBreakerThreshold:       2,
+		CircuitBreakerCooldownSeconds: 60,
+	}
+
+	handler := newHandler(t, cfg)
+	for i := 0; i < 2; i++ {
+		recorder := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/data", nil)
+		handler.ServeHTTP(recorder, req)
+	}
+	if calls != 2 {
+		t.Fatalf("expected 2 auth-grant calls before the breaker opens, got %d", calls)
+	}
+
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/data", nil)
+	handler.ServeHTTP(recorder, req)
+	if recorder.Result().StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected 503 from the open breaker, got %d", recorder.Result().StatusCode)
+	}
+	if calls != 2 {
+		t.Errorf("expected the breaker to short-circuit without an extra auth-grant call, got %d calls", calls)
+	}
+}
+
+func newHandler(t *testing.T, cfg *Config) http.Handler {
+	t.Helper()
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {})
+	handler, err := New(nil, next, cfg, "test") //nolint:staticcheck // Traefik passes a real context; nil is fine in tests
+	if err != nil {
+		t.Fatal(fmt.Errorf("new: %w", err))
+	}
+	return handler
+}
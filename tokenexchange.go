@@ -0,0 +1,177 @@
+package traefik_outgoing_oauth2_cc
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const modeClientCredentials = "client_credentials"
+const modeTokenExchange = "token_exchange"
+
+// TokenExchangeConfig configures RFC 8693 token-exchange mode, used when AuthGrantRequest.Mode is
+// "token_exchange" to propagate the caller's identity to the upstream instead of a single shared
+// client-credentials token.
+type TokenExchangeConfig struct {
+	Audience                    string `json:"audience,omitempty"`                    // optional "audience" form field
+	Resource                    string `json:"resource,omitempty"`                    // optional "resource" form field
+	RequestedTokenType          string `json:"requestedTokenType,omitempty"`          // optional "requested_token_type" form field
+	FallbackToClientCredentials bool   `json:"fallbackToClientCredentials,omitempty"` // use client_credentials when the inbound request has no bearer token, instead of rejecting it
+	CacheSize                   int    `json:"cacheSize,omitempty"`                   // bounded LRU size for exchanged tokens, defaults to 1000
+}
+
+const defaultExchangeCacheSize = 1000
+
+const subjectTokenType = "urn:ietf:params:oauth:token-type:access_token"
+const tokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+
+// tokenForExchange resolves the token for token-exchange mode: it reuses a still-valid exchanged
+// token cached under a hash of the inbound bearer, or exchanges it anew and caches the result.
+// Concurrent requests bearing the same inbound bearer coalesce onto a single exchange via the
+// cache entry's own lock, the same double-checked-locking pattern fetchToken uses around fetchMu.
+func (c *OutgoingOAuth2CC) tokenForExchange(req *http.Request) (state, error) {
+	inbound := bearerToken(req)
+	if inbound == "" {
+		if c.fallbackToClientCredentials {
+			return c.token()
+		}
+		return state{}, httpStatusError{statusCode: http.StatusUnauthorized, msg: "token-exchange: request has no inbound bearer token"}
+	}
+
+	key := exchangeCacheKey(inbound)
+	if cached, ok := c.exchangeCache.get(key); ok && time.Now().Before(cached.expires) {
+		return cached, nil
+	}
+
+	mu := c.exchangeCache.lockFor(key)
+	mu.Lock()
+	defer mu.Unlock()
+	if cached, ok := c.exchangeCache.get(key); ok && time.Now().Before(cached.expires) {
+		return cached, nil // someone else already exchanged while we waited for the per-key lock
+	}
+
+	st, err := c.requestTokenExchange(inbound)
+	if err != nil {
+		return state{}, err
+	}
+	c.exchangeCache.set(key, st)
+	return st, nil
+}
+
+// requestTokenExchange performs the RFC 8693 token-exchange auth-grant request for a single
+// inbound token, reusing the same client authentication and retry/circuit-breaker machinery as
+// the client-credentials grant.
+func (c *OutgoingOAuth2CC) requestTokenExchange(inboundToken string) (state, error) {
+	data := url.Values{
+		"grant_type":         {tokenExchangeGrantType},
+		"subject_token":      {inboundToken},
+		"subject_token_type": {subjectTokenType},
+	}
+	if c.authGrantScope != "" {
+		data.Set("scope", c.authGrantScope)
+	}
+	if c.exchangeAudience != "" {
+		data.Set("audience", c.exchangeAudience)
+	}
+	if c.exchangeResource != "" {
+		data.Set("resource", c.exchangeResource)
+	}
+	if c.exchangeRequestedTokenType != "" {
+		data.Set("requested_token_type", c.exchangeRequestedTokenType)
+	}
+	return c.performAuthGrant(data)
+}
+
+func bearerToken(req *http.Request) string {
+	const prefix = "Bearer "
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, prefix) {
+		return ""
+	}
+	return strings.TrimPrefix(auth, prefix)
+}
+
+// exchangeCacheKey hashes the inbound token so exchanged tokens aren't cross-used between callers
+// and the raw caller token never itself sits in the cache's key space.
+func exchangeCacheKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// lruCache is a small, bounded, concurrency-safe least-recently-used cache of exchanged token
+// state, keyed by exchangeCacheKey, so a high-cardinality stream of callers can't grow it unbounded.
+type lruCache struct {
+	mu       sync.Mutex
+	capacity int
+	items    map[string]*list.Element
+	order    *list.List
+}
+
+type lruEntry struct {
+	key   string
+	value state
+	mu    *sync.Mutex // lazily created by lockFor; serializes fetches for this one key
+}
+
+func newLRUCache(capacity int) *lruCache {
+	if capacity <= 0 {
+		capacity = defaultExchangeCacheSize
+	}
+	return &lruCache{capacity: capacity, items: make(map[string]*list.Element), order: list.New()}
+}
+
+func (c *lruCache) get(key string) (state, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return state{}, false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*lruEntry).value, true
+}
+
+func (c *lruCache) set(key string, value state) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		el.Value.(*lruEntry).value = value
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.order.PushFront(&lruEntry{key: key, value: value})
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruEntry).key)
+	}
+}
+
+// lockFor returns the per-key mutex used to serialize concurrent token-exchange fetches for the
+// same cache key, creating one (and an empty entry to hold it) on first use. It participates in
+// the same LRU bookkeeping as get/set so a key's lock is reclaimed along with its cached value.
+func (c *lruCache) lockFor(key string) *sync.Mutex {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		entry := el.Value.(*lruEntry)
+		if entry.mu == nil {
+			entry.mu = &sync.Mutex{}
+		}
+		c.order.MoveToFront(el)
+		return entry.mu
+	}
+	entry := &lruEntry{key: key, mu: &sync.Mutex{}}
+	c.items[key] = c.order.PushFront(entry)
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruEntry).key)
+	}
+	return entry.mu
+}
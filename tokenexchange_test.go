@@ -0,0 +1,114 @@
+package traefik_outgoing_oauth2_cc
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestTokenExchangeModePropagatesCallerIdentity(t *testing.T) {
+	var subjectTokens []string
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		_ = req.ParseForm()
+		if req.PostForm.Get("grant_type") != tokenExchangeGrantType {
+			t.Errorf("expected grant_type %q, got %q", tokenExchangeGrantType, req.PostForm.Get("grant_type"))
+		}
+		subjectTokens = append(subjectTokens, req.PostForm.Get("subject_token"))
+		_, _ = rw.Write([]byte(`{"access_token": "exchanged-` + req.PostForm.Get("subject_token") + `", "expires_in": 3600}`))
+	}))
+	defer server.Close()
+
+	cfg := CreateConfig()
+	cfg.AuthGrantRequest.URL = server.URL + "/auth"
+	cfg.AuthGrantRequest.Mode = "token_exchange"
+
+	handler := newHandler(t, cfg)
+
+	call := func(inboundToken string) string {
+		recorder := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/data", nil)
+		req.Header.Set("Authorization", "Bearer "+inboundToken)
+		handler.ServeHTTP(recorder, req)
+		if recorder.Result().StatusCode != http.StatusOK {
+			t.Fatalf("expected 200, got %d", recorder.Result().StatusCode)
+		}
+		return req.Header.Get("Authorization")
+	}
+
+	if got := call("caller-a"); got != "Bearer exchanged-caller-a" {
+		t.Errorf("unexpected Authorization for caller-a: %s", got)
+	}
+	if got := call("caller-b"); got != "Bearer exchanged-caller-b" {
+		t.Errorf("unexpected Authorization for caller-b: %s", got)
+	}
+	call("caller-a") // should hit the per-caller cache, not exchange again
+
+	if len(subjectTokens) != 2 {
+		t.Errorf("expected exactly 2 token-exchange calls (one per distinct caller), got %d: %v", len(subjectTokens), subjectTokens)
+	}
+}
+
+// TestTokenExchangeConcurrentSameCallerSingleFlight fires a burst of concurrent requests bearing
+// the identical inbound bearer token and asserts the auth-grant endpoint is only exchanged against
+// once: the rest must coalesce onto that one exchange instead of each missing the cache.
+func TestTokenExchangeConcurrentSameCallerSingleFlight(t *testing.T) {
+	var exchangeCalls int32
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&exchangeCalls, 1)
+		_, _ = rw.Write([]byte(`{"access_token": "exchanged-token", "expires_in": 3600}`))
+	}))
+	defer server.Close()
+
+	cfg := CreateConfig()
+	cfg.AuthGrantRequest.URL = server.URL + "/auth"
+	cfg.AuthGrantRequest.Mode = "token_exchange"
+
+	handler := newHandler(t, cfg)
+
+	const concurrency = 20
+	var wg sync.WaitGroup
+	wg.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			defer wg.Done()
+			recorder := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodGet, server.URL+"/data", nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			req.Header.Set("Authorization", "Bearer same-caller-token")
+			handler.ServeHTTP(recorder, req)
+			if recorder.Result().StatusCode != http.StatusOK {
+				t.Errorf("http status mismatch: expected %d != %d actual", http.StatusOK, recorder.Result().StatusCode)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if exchangeCalls != 1 {
+		t.Errorf("expected exactly 1 token-exchange request, got %d", exchangeCalls)
+	}
+}
+
+func TestTokenExchangeWithoutFallbackRejectsMissingBearer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		t.Error("auth-grant endpoint should not have been called")
+	}))
+	defer server.Close()
+
+	cfg := CreateConfig()
+	cfg.AuthGrantRequest.URL = server.URL + "/auth"
+	cfg.AuthGrantRequest.Mode = "token_exchange"
+
+	handler := newHandler(t, cfg)
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/data", nil)
+	handler.ServeHTTP(recorder, req)
+
+	if recorder.Result().StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected 401, got %d", recorder.Result().StatusCode)
+	}
+}
@@ -0,0 +1,389 @@
+package traefik_outgoing_oauth2_cc
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TokenStoreConfig configures a store shared across Traefik replicas, so only one replica ever
+// negotiates a token for a given IdP/client and the rest reuse it from the shared store.
+type TokenStoreConfig struct {
+	Type      string `json:"type,omitempty"`      // memory (default, no sharing), file or redis
+	Addr      string `json:"addr,omitempty"`      // file: the shared directory; redis: host:port
+	Password  string `json:"password,omitempty"`  // redis only
+	KeyPrefix string `json:"keyPrefix,omitempty"` // prefix applied to the derived cache key
+}
+
+const tokenStoreLockTTL = 10 * time.Second
+
+// TokenStore is a pluggable, shared place to cache the token state across plugin instances.
+type TokenStore interface {
+	Get(key string) (state, bool, error)
+	Set(key string, st state, ttl time.Duration) error
+	// Lock obtains an exclusive, auto-expiring lock on key, blocking until it is acquired or ttl
+	// elapses. The returned unlock releases it; callers must always call it. unlock is a fenced
+	// release: if ttl already passed and another caller reclaimed the lock, unlock is a no-op
+	// instead of deleting the new holder's lock out from under it.
+	Lock(key string, ttl time.Duration) (func(), error)
+}
+
+// newTokenStore builds the configured TokenStore, or nil for the default in-process-only cache.
+func newTokenStore(cfg TokenStoreConfig) (TokenStore, error) {
+	switch cfg.Type {
+	case "", "memory":
+		return nil, nil
+	case "file":
+		if cfg.Addr == "" {
+			return nil, fmt.Errorf("outgoing-oauth2-cc tokenStore.type=file requires addr (the shared directory)")
+		}
+		return newFileStore(cfg.Addr), nil
+	case "redis":
+		if cfg.Addr == "" {
+			return nil, fmt.Errorf("outgoing-oauth2-cc tokenStore.type=redis requires addr (host:port)")
+		}
+		return newRedisStore(cfg.Addr, cfg.Password, cfg.KeyPrefix), nil
+	default:
+		return nil, fmt.Errorf("outgoing-oauth2-cc unknown tokenStore.type: %s", cfg.Type)
+	}
+}
+
+// tokenStoreKey hashes (url, user, scope) so multiple plugin instances targeting the same
+// IdP/client share one cache entry, without leaking credentials or URLs into the store's key space.
+func tokenStoreKey(prefix, url, user, scope string) string {
+	sum := sha256.Sum256([]byte(url + "\x00" + user + "\x00" + scope))
+	return prefix + hex.EncodeToString(sum[:])
+}
+
+// storedState is the JSON-serializable form of state persisted to an external TokenStore.
+type storedState struct {
+	Token    string    `json:"token"`
+	Expires  time.Time `json:"expires"`
+	Obtained time.Time `json:"obtained"`
+	Subject  string    `json:"subject,omitempty"`
+	Scope    string    `json:"scope,omitempty"`
+}
+
+func toStoredState(st state) storedState {
+	return storedState{Token: st.token, Expires: st.expires, Obtained: st.obtained, Subject: st.subject, Scope: st.scope}
+}
+
+func (s storedState) toState() state {
+	return state{token: s.Token, expires: s.Expires, obtained: s.Obtained, subject: s.Subject, scope: s.Scope}
+}
+
+// FileStore persists token state as one JSON file per key under a shared directory. The
+// distributed lock is a plain create-exclusive lock file rather than flock: flock needs the
+// syscall package, which isn't part of the curated stdlib subset Traefik's Yaegi interpreter
+// exposes to plugins.
+type FileStore struct {
+	dir string
+}
+
+func newFileStore(dir string) *FileStore {
+	return &FileStore{dir: dir}
+}
+
+func (f *FileStore) Get(key string) (state, bool, error) {
+	data, err := os.ReadFile(f.path(key))
+	if os.IsNotExist(err) {
+		return state{}, false, nil
+	}
+	if err != nil {
+		return state{}, false, err
+	}
+	var stored storedState
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return state{}, false, err
+	}
+	return stored.toState(), true, nil
+}
+
+// Set writes the new state atomically (write to a temp file, then rename) so concurrent readers
+// never observe a partially written file.
+func (f *FileStore) Set(key string, st state, _ time.Duration) error {
+	if err := os.MkdirAll(f.dir, 0700); err != nil {
+		return err
+	}
+	data, err := json.Marshal(toStoredState(st))
+	if err != nil {
+		return err
+	}
+	tmp := f.path(key) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path(key))
+}
+
+// Lock takes a dedicated lock file for key, created with O_EXCL so only one caller ever wins the
+// create; the file's content is a random owner token plus the lock's own expiry (mirroring
+// RedisStore's "SET token EX ttl"), so a holder that crashes or hangs past its declared ttl
+// doesn't wedge the key for everyone else, and unlock can tell whether it still owns the lock it's
+// about to release. It polls until it wins the create or its own ttl elapses, matching
+// RedisStore.Lock's approach.
+func (f *FileStore) Lock(key string, ttl time.Duration) (func(), error) {
+	if err := os.MkdirAll(f.dir, 0700); err != nil {
+		return nil, err
+	}
+	lockPath := f.path(key) + ".lock"
+	token := randomToken()
+	content := token + "\n" + time.Now().Add(ttl).Format(time.RFC3339Nano)
+	pollDeadline := time.Now().Add(ttl)
+	for {
+		lockFile, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0600)
+		if err == nil {
+			if _, werr := lockFile.WriteString(content); werr != nil {
+				_ = lockFile.Close()
+				_ = os.Remove(lockPath)
+				return nil, werr
+			}
+			_ = lockFile.Close()
+			return func() { unlockFileIfOwned(lockPath, token) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if fileLockExpired(lockPath) {
+			_ = os.Remove(lockPath) // the previous holder is gone/stuck past its declared ttl; steal it
+			continue
+		}
+		if time.Now().After(pollDeadline) {
+			return nil, fmt.Errorf("file lock %q: timed out waiting for the lock", key)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// unlockFileIfOwned removes the lock file at lockPath only if it still carries token, so a caller
+// whose ttl already elapsed and whose lock was reclaimed by someone else can't delete the new
+// holder's lock out from under it. The read-then-remove has a residual TOCTOU window, accepted as
+// adequately bounded by how short that read is.
+func unlockFileIfOwned(lockPath, token string) {
+	owner, _, ok := parseFileLock(lockPath)
+	if ok && owner == token {
+		_ = os.Remove(lockPath)
+	}
+}
+
+// fileLockExpired reports whether the lock file at lockPath carries an expiry that has passed. An
+// unreadable or malformed lock file (e.g. a concurrent unlock racing the read) is treated as not
+// yet expired, so the caller just keeps polling rather than fighting over a file mid-removal.
+func fileLockExpired(lockPath string) bool {
+	_, expiry, ok := parseFileLock(lockPath)
+	return ok && time.Now().After(expiry)
+}
+
+// parseFileLock reads and splits a lock file's "token\nexpiry" content.
+func parseFileLock(lockPath string) (token string, expiry time.Time, ok bool) {
+	data, err := os.ReadFile(lockPath)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	parts := strings.SplitN(string(data), "\n", 2)
+	if len(parts) != 2 {
+		return "", time.Time{}, false
+	}
+	expiry, err = time.Parse(time.RFC3339Nano, parts[1])
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	return parts[0], expiry, true
+}
+
+func (f *FileStore) path(key string) string {
+	return filepath.Join(f.dir, key+".json")
+}
+
+// RedisStore talks plain RESP over a net.Conn, implementing only the handful of commands needed
+// (GET, SET ... EX ... [NX], DEL) by hand to stay Yaegi-compatible without an external client lib.
+type RedisStore struct {
+	addr     string
+	password string
+	prefix   string
+}
+
+func newRedisStore(addr, password, prefix string) *RedisStore {
+	return &RedisStore{addr: addr, password: password, prefix: prefix}
+}
+
+func (r *RedisStore) Get(key string) (state, bool, error) {
+	conn, err := r.dial()
+	if err != nil {
+		return state{}, false, err
+	}
+	defer func() { _ = conn.Close() }()
+
+	reply, err := r.command(conn, "GET", r.prefix+key)
+	if err != nil {
+		return state{}, false, err
+	}
+	if reply == nil {
+		return state{}, false, nil
+	}
+	var stored storedState
+	if err := json.Unmarshal([]byte(reply.(string)), &stored); err != nil {
+		return state{}, false, err
+	}
+	return stored.toState(), true, nil
+}
+
+func (r *RedisStore) Set(key string, st state, ttl time.Duration) error {
+	conn, err := r.dial()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = conn.Close() }()
+
+	data, err := json.Marshal(toStoredState(st))
+	if err != nil {
+		return err
+	}
+	_, err = r.command(conn, "SET", r.prefix+key, string(data), "EX", strconv.Itoa(secondsAtLeastOne(ttl)))
+	return err
+}
+
+// Lock polls SET key token EX ttl NX until it wins the key or ttl elapses, since RESP's SET NX is
+// a try-lock rather than a blocking one. token is a random owner value so unlock can tell whether
+// it still owns the key before deleting it.
+func (r *RedisStore) Lock(key string, ttl time.Duration) (func(), error) {
+	lockKey := r.prefix + "lock:" + key
+	token := randomToken()
+	deadline := time.Now().Add(ttl)
+	for {
+		conn, err := r.dial()
+		if err != nil {
+			return nil, err
+		}
+		reply, err := r.command(conn, "SET", lockKey, token, "EX", strconv.Itoa(secondsAtLeastOne(ttl)), "NX")
+		_ = conn.Close()
+		if err != nil {
+			return nil, err
+		}
+		if reply == "OK" {
+			return func() { r.unlockIfOwned(lockKey, token) }, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("redis lock %q: timed out waiting for the lock", key)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// unlockIfOwned deletes lockKey only if it still holds token, so a caller whose ttl already
+// expired and whose lock was reclaimed by another replica can't delete the new holder's key out
+// from under it. This GET-then-DEL isn't atomic (a true compare-and-delete needs EVAL/Lua, which
+// this hand-rolled RESP client doesn't implement), but closes the unconditional-delete race that
+// matters in practice.
+func (r *RedisStore) unlockIfOwned(lockKey, token string) {
+	conn, err := r.dial()
+	if err != nil {
+		return
+	}
+	defer func() { _ = conn.Close() }()
+	reply, err := r.command(conn, "GET", lockKey)
+	if err != nil || reply != token {
+		return
+	}
+	_, _ = r.command(conn, "DEL", lockKey)
+}
+
+func (r *RedisStore) dial() (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", r.addr, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if r.password != "" {
+		if _, err := r.command(conn, "AUTH", r.password); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func (r *RedisStore) command(conn net.Conn, args ...string) (interface{}, error) {
+	if _, err := conn.Write(respEncode(args...)); err != nil {
+		return nil, err
+	}
+	return respReadReply(bufio.NewReader(conn))
+}
+
+// respEncode renders args as a RESP array of bulk strings, the wire format redis-server expects
+// for commands.
+func respEncode(args ...string) []byte {
+	b := strings.Builder{}
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	return []byte(b.String())
+}
+
+// respReadReply parses a single RESP reply: simple string, error, integer, or bulk string
+// (nil for $-1). Arrays are not needed by the commands this store issues.
+func respReadReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, fmt.Errorf("redis: %s", line[1:])
+	case ':':
+		n, err := strconv.ParseInt(line[1:], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		return n, nil
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil // nil bulk string, e.g. GET on a missing key
+		}
+		buf := make([]byte, n+2) // +2 for the trailing CRLF
+		if _, err := readFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	default:
+		return nil, fmt.Errorf("redis: unsupported reply type %q", line)
+	}
+}
+
+func readFull(r *bufio.Reader, buf []byte) (int, error) {
+	total := 0
+	for total < len(buf) {
+		n, err := r.Read(buf[total:])
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+func secondsAtLeastOne(d time.Duration) int {
+	secs := int(d.Seconds())
+	if secs < 1 {
+		secs = 1
+	}
+	return secs
+}
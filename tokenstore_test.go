@@ -0,0 +1,213 @@
+package traefik_outgoing_oauth2_cc
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestFileTokenStoreIsSharedAcrossInstances simulates two plugin instances (e.g. two Traefik
+// replicas) pointed at the same file-backed TokenStore: only the first should ever hit the IdP.
+func TestFileTokenStoreIsSharedAcrossInstances(t *testing.T) {
+	var authCalls int
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		authCalls++
+		_, _ = rw.Write([]byte(`{"access_token": "shared_token", "expires_in": 3600}`))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	cfg := CreateConfig()
+	cfg.AuthGrantRequest.URL = server.URL + "/auth"
+	cfg.TokenStore.Type = "file"
+	cfg.TokenStore.Addr = dir
+
+	first := newHandler(t, cfg)
+	second := newHandler(t, cfg)
+
+	call := func(h http.Handler) {
+		recorder := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, server.URL+"/data", nil)
+		h.ServeHTTP(recorder, req)
+		if recorder.Result().StatusCode != http.StatusOK {
+			t.Fatalf("expected 200, got %d", recorder.Result().StatusCode)
+		}
+		if req.Header.Get("Authorization") != "Bearer shared_token" {
+			t.Errorf("unexpected Authorization header: %s", req.Header.Get("Authorization"))
+		}
+	}
+
+	call(first)
+	call(second) // must pick up the token the first instance wrote to the shared store
+
+	if authCalls != 1 {
+		t.Errorf("expected exactly 1 auth-grant request across both instances, got %d", authCalls)
+	}
+}
+
+// TestFileStoreLockSerializesConcurrentCallers asserts that a second Lock for the same key blocks
+// until the first caller's unlock, instead of both succeeding concurrently.
+func TestFileStoreLockSerializesConcurrentCallers(t *testing.T) {
+	store := newFileStore(t.TempDir())
+
+	unlock, err := store.Lock("key", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	acquired := make(chan struct{})
+	go func() {
+		unlock2, err := store.Lock("key", time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		close(acquired)
+		unlock2()
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("second Lock acquired the key while the first still held it")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	unlock()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("second Lock never acquired the key after unlock")
+	}
+}
+
+// TestFileStoreLockTimesOutPastTTL asserts Lock gives up and returns an error once ttl elapses,
+// rather than blocking forever behind a holder that never releases.
+func TestFileStoreLockTimesOutPastTTL(t *testing.T) {
+	store := newFileStore(t.TempDir())
+
+	unlock, err := store.Lock("key", time.Hour) // held well past the second caller's ttl
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+
+	if _, err := store.Lock("key", 200*time.Millisecond); err == nil {
+		t.Error("expected Lock to time out while the key is still held")
+	}
+}
+
+// TestFileStoreLockReclaimsStaleLock asserts a lock file left behind by a holder that never
+// unlocked (e.g. a crashed replica) is stolen once it's older than ttl, instead of wedging the key
+// forever.
+func TestFileStoreLockReclaimsStaleLock(t *testing.T) {
+	store := newFileStore(t.TempDir())
+
+	// simulate a holder that crashed without unlocking, past the ttl it locked with.
+	lockFile, err := os.OpenFile(store.path("key")+".lock", os.O_CREATE|os.O_EXCL|os.O_RDWR, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiredAt := time.Now().Add(-time.Hour).Format(time.RFC3339Nano)
+	if _, err := lockFile.WriteString("stale-owner-token\n" + expiredAt); err != nil {
+		t.Fatal(err)
+	}
+	_ = lockFile.Close()
+
+	unlock, err := store.Lock("key", 200*time.Millisecond)
+	if err != nil {
+		t.Fatalf("expected Lock to reclaim the stale lock file, got: %v", err)
+	}
+	unlock()
+}
+
+// TestFileStoreUnlockIsFencedAgainstAReclaimedLock reproduces the scenario where holder A's ttl
+// expires mid-fetch, holder B reclaims the lock, and A finally calls its own (now stale) unlock:
+// that unlock must not delete B's lock out from under it, so a third Lock call still has to wait
+// for B instead of acquiring concurrently with it.
+func TestFileStoreUnlockIsFencedAgainstAReclaimedLock(t *testing.T) {
+	store := newFileStore(t.TempDir())
+
+	unlockA, err := store.Lock("key", 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond) // let A's ttl lapse, simulating a slow IdP round trip
+
+	unlockB, err := store.Lock("key", time.Second) // B reclaims the stale lock
+	if err != nil {
+		t.Fatalf("expected B to reclaim the stale lock, got: %v", err)
+	}
+
+	unlockA() // A finally finishes and releases its (now stale) view of the lock
+
+	cAcquired := make(chan struct{})
+	go func() {
+		unlockC, err := store.Lock("key", time.Second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		close(cAcquired)
+		unlockC()
+	}()
+
+	select {
+	case <-cAcquired:
+		t.Fatal("a third Lock acquired the key while B still held it: A's stale unlock deleted B's lock")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	unlockB()
+	select {
+	case <-cAcquired:
+	case <-time.After(time.Second):
+		t.Fatal("third Lock never acquired the key after B's unlock")
+	}
+}
+
+// lockFailingTokenStore simulates a TokenStore whose backing store (Redis, an NFS share, ...) is
+// unreachable: every Lock call fails, as it would on a transient outage.
+type lockFailingTokenStore struct{}
+
+func (lockFailingTokenStore) Get(string) (state, bool, error)        { return state{}, false, nil }
+func (lockFailingTokenStore) Set(string, state, time.Duration) error { return nil }
+func (lockFailingTokenStore) Lock(string, time.Duration) (func(), error) {
+	return nil, fmt.Errorf("store unreachable")
+}
+
+// TestTokenStoreLockFailureFallsBackToDirectFetch asserts that a TokenStore outage degrades to a
+// direct IdP fetch instead of failing the caller's request.
+func TestTokenStoreLockFailureFallsBackToDirectFetch(t *testing.T) {
+	var authCalls int
+	server := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		authCalls++
+		_, _ = rw.Write([]byte(`{"access_token": "direct_token", "expires_in": 3600}`))
+	}))
+	defer server.Close()
+
+	cfg := CreateConfig()
+	cfg.AuthGrantRequest.URL = server.URL + "/auth"
+	next := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})
+	grant, err := newGrantHandler(next, "test", false, TokenStoreConfig{}, cfg.AuthGrantRequest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grant.tokenStore = lockFailingTokenStore{}
+	grant.tokenStoreKey = "test-key"
+
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/data", nil)
+	grant.ServeHTTP(recorder, req)
+	if recorder.Result().StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", recorder.Result().StatusCode)
+	}
+	if req.Header.Get("Authorization") != "Bearer direct_token" {
+		t.Errorf("unexpected Authorization header: %s", req.Header.Get("Authorization"))
+	}
+	if authCalls != 1 {
+		t.Errorf("expected exactly 1 direct auth-grant request, got %d", authCalls)
+	}
+}